@@ -0,0 +1,98 @@
+// Package chaos provides a failure-injection harness for the failover pipeline,
+// modelled on etcd's robustness-test failpoints. It lets integration tests force a
+// specific step boundary in Failover.Run to sleep, fail, panic or drop a client
+// connection, without scattering test-only conditionals through the real step
+// implementations.
+package chaos
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Failpoint describes a single fault to inject at a named step boundary. Exactly one of
+// Sleep, Return, Panic or Kill applies to a given Failpoint; the zero value is a no-op.
+type Failpoint struct {
+	sleep  time.Duration
+	err    error
+	panic  string
+	client string
+}
+
+// Sleep builds a Failpoint that blocks the step boundary for d before continuing as
+// normal, modelling a delayed watch or a slow network round-trip.
+func Sleep(d time.Duration) Failpoint {
+	return Failpoint{sleep: d}
+}
+
+// Return builds a Failpoint that makes the step boundary fail with err.
+func Return(err error) Failpoint {
+	return Failpoint{err: err}
+}
+
+// Panic builds a Failpoint that panics with msg, exercising the orchestrator's recover
+// paths the same way a genuinely broken response deserializer would.
+func Panic(msg string) Failpoint {
+	return Failpoint{panic: msg}
+}
+
+// Kill builds a Failpoint that drops the named pgbouncer client's connection at the step
+// boundary, as if that one endpoint had become unreachable mid-failover.
+func Kill(client string) Failpoint {
+	return Failpoint{client: client}
+}
+
+// Hit applies the failpoint's action. A zero Failpoint does nothing, and neither does a
+// Kill failpoint, whose endpoint is read through Killed instead. Sleep respects ctx
+// cancellation rather than blocking past it.
+func (f Failpoint) Hit(ctx context.Context) error {
+	if f.sleep > 0 {
+		select {
+		case <-time.After(f.sleep):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if f.panic != "" {
+		panic(f.panic)
+	}
+
+	return f.err
+}
+
+// Killed returns the endpoint this failpoint drops, and whether it drops one at all.
+func (f Failpoint) Killed() (string, bool) {
+	return f.client, f.client != ""
+}
+
+// Failpoints maps step boundary names (e.g. "pause.pre", "failkeeper.pre",
+// "notify_recovered.first_event") to the fault that should be injected there. Real code
+// paths call Failpoints.Hit unconditionally; a nil or empty map, the production default,
+// is always a no-op, so chaos testing never leaks a conditional into Run itself.
+type Failpoints map[string]Failpoint
+
+// Hit applies whichever failpoint is registered under name, if any.
+func (fps Failpoints) Hit(ctx context.Context, name string) error {
+	if fp, ok := fps[name]; ok {
+		return fp.Hit(ctx)
+	}
+
+	return nil
+}
+
+// Killed reports the endpoint that the failpoint registered under name drops, if it is a
+// Kill failpoint.
+func (fps Failpoints) Killed(name string) (string, bool) {
+	if fp, ok := fps[name]; ok {
+		return fp.Killed()
+	}
+
+	return "", false
+}
+
+// ErrKilled is returned to a client whose connection a Kill failpoint has dropped.
+func ErrKilled(endpoint string) error {
+	return fmt.Errorf("chaos: connection to %s dropped", endpoint)
+}
@@ -0,0 +1,61 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFailpointsHitNoOpWhenUnset(t *testing.T) {
+	var fps Failpoints
+
+	if err := fps.Hit(context.Background(), "pause.pre"); err != nil {
+		t.Fatalf("expected nil error from unset failpoint, got %v", err)
+	}
+}
+
+func TestFailpointsHitReturn(t *testing.T) {
+	boom := errors.New("boom")
+	fps := Failpoints{"pause.pre": Return(boom)}
+
+	if err := fps.Hit(context.Background(), "pause.pre"); !errors.Is(err, boom) {
+		t.Fatalf("expected %v, got %v", boom, err)
+	}
+}
+
+func TestFailpointsHitPanics(t *testing.T) {
+	fps := Failpoints{"failkeeper.pre": Panic("injected panic")}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("expected Hit to panic")
+		}
+	}()
+
+	fps.Hit(context.Background(), "failkeeper.pre")
+}
+
+func TestFailpointsHitSleepRespectsCancellation(t *testing.T) {
+	fps := Failpoints{"pause.post": Sleep(time.Hour)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := fps.Hit(ctx, "pause.post"); err == nil {
+		t.Fatal("expected cancelled context to interrupt the sleep")
+	}
+}
+
+func TestFailpointsKilled(t *testing.T) {
+	fps := Failpoints{"pause.pre": Kill("pgbouncer-1")}
+
+	endpoint, ok := fps.Killed("pause.pre")
+	if !ok || endpoint != "pgbouncer-1" {
+		t.Fatalf("expected pgbouncer-1, true; got %q, %v", endpoint, ok)
+	}
+
+	if _, ok := fps.Killed("pause.post"); ok {
+		t.Fatal("expected no kill registered for pause.post")
+	}
+}
@@ -0,0 +1,277 @@
+// Package etcdx wraps a *clientv3.Client with health-aware endpoint bookkeeping and
+// bounded, cause-annotated retries: round-robin across endpoints, note which ones are
+// failing a periodic Status RPC, and back off with jitter between attempts rather than
+// retrying immediately against a partitioned follower. The endpoint identified as
+// healthiest is surfaced to callers for logging only - see Retry's doc comment for why
+// it is not used to force an individual call's transport-level routing.
+package etcdx
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/pkg/errors"
+)
+
+// ErrSessionLost is returned by Locker.Lock when the underlying etcd session has expired
+// before, or while, the lock was being acquired. Callers should treat this as fatal to
+// the current attempt and bail out before pausing traffic, rather than pausing and only
+// then discovering the lock is gone.
+var ErrSessionLost = errors.New("etcd session lost")
+
+// ErrRetriesExhausted is the cause wrapped around the last error once Retry has tried
+// every attempt it's allowed.
+var ErrRetriesExhausted = errors.New("exhausted retries against etcd")
+
+// Options configures a Client's endpoint health checking and retry behaviour.
+type Options struct {
+	// HealthCheckInterval is how often each endpoint's Status RPC is polled to decide
+	// whether endpoint selection should skip it. Defaults to DefaultHealthCheckInterval.
+	HealthCheckInterval time.Duration
+
+	// HealthCheckTimeout bounds a single Status RPC. Defaults to DefaultHealthCheckTimeout.
+	HealthCheckTimeout time.Duration
+
+	// MaxRetries bounds how many additional attempts a retried operation gets beyond its
+	// first. Defaults to DefaultMaxRetries.
+	MaxRetries int
+
+	// BackoffBase is the starting backoff between retries, doubled (with jitter) on each
+	// subsequent attempt. Defaults to DefaultBackoffBase.
+	BackoffBase time.Duration
+}
+
+// Defaults applied to any zero-valued Options field.
+const (
+	DefaultHealthCheckInterval = 5 * time.Second
+	DefaultHealthCheckTimeout  = time.Second
+	DefaultMaxRetries          = 3
+	DefaultBackoffBase         = 100 * time.Millisecond
+)
+
+func (o Options) withDefaults() Options {
+	if o.HealthCheckInterval == 0 {
+		o.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+
+	if o.HealthCheckTimeout == 0 {
+		o.HealthCheckTimeout = DefaultHealthCheckTimeout
+	}
+
+	if o.MaxRetries == 0 {
+		o.MaxRetries = DefaultMaxRetries
+	}
+
+	if o.BackoffBase == 0 {
+		o.BackoffBase = DefaultBackoffBase
+	}
+
+	return o
+}
+
+// Client wraps a *clientv3.Client, periodically health checking its endpoints so Retry can
+// name a healthy endpoint to each attempt. Retried operations still run through the
+// wrapped client's own connection; Client does not route them to that endpoint.
+type Client struct {
+	*clientv3.Client
+	opt Options
+
+	mu        sync.Mutex
+	endpoints []string
+	healthy   map[string]bool
+	next      int
+}
+
+// New wraps client, immediately marking every configured endpoint healthy and starting a
+// background health checker that runs until ctx is cancelled. It does not close client.
+func New(ctx context.Context, client *clientv3.Client, opt Options) *Client {
+	opt = opt.withDefaults()
+	endpoints := client.Endpoints()
+
+	c := &Client{
+		Client:    client,
+		opt:       opt,
+		endpoints: endpoints,
+		healthy:   make(map[string]bool, len(endpoints)),
+	}
+
+	for _, endpoint := range endpoints {
+		c.healthy[endpoint] = true
+	}
+
+	go c.watchHealth(ctx)
+
+	return c
+}
+
+func (c *Client) watchHealth(ctx context.Context) {
+	ticker := time.NewTicker(c.opt.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkEndpoints(ctx)
+		}
+	}
+}
+
+func (c *Client) checkEndpoints(ctx context.Context) {
+	for _, endpoint := range c.endpoints {
+		checkCtx, cancel := context.WithTimeout(ctx, c.opt.HealthCheckTimeout)
+		_, err := c.Client.Status(checkCtx, endpoint)
+		cancel()
+
+		c.mu.Lock()
+		c.healthy[endpoint] = err == nil
+		c.mu.Unlock()
+	}
+}
+
+// pickEndpoint returns the next healthy endpoint in round-robin order, falling back to
+// whichever endpoint comes up next even if every endpoint currently looks unhealthy, so
+// a transient false negative in health checking can never wedge every retry.
+func (c *Client) pickEndpoint() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.endpoints) == 0 {
+		return ""
+	}
+
+	for i := 0; i < len(c.endpoints); i++ {
+		endpoint := c.endpoints[c.next%len(c.endpoints)]
+		c.next++
+
+		if c.healthy[endpoint] {
+			return endpoint
+		}
+	}
+
+	endpoint := c.endpoints[c.next%len(c.endpoints)]
+	c.next++
+
+	return endpoint
+}
+
+// backoff returns a jittered exponential backoff for the given 0-indexed retry attempt.
+func (c *Client) backoff(attempt int) time.Duration {
+	base := c.opt.BackoffBase * time.Duration(int64(1)<<uint(attempt))
+	return base/2 + time.Duration(rand.Int63n(int64(base/2+1)))
+}
+
+// Retry runs action up to MaxRetries+1 times, backing off with jitter between attempts.
+// action is told which endpoint currently looks healthiest by round-robin, for logging
+// and diagnostics, but Retry deliberately does not force the call onto that endpoint at
+// the transport level: the client passed to New is shared with other long-lived
+// operations (this package's own health-check Status RPCs, the caller's etcd session
+// keepalive, any concurrent watch streams), and globally repointing it via
+// clientv3.Client.SetEndpoints for the duration of one call would briefly reroute all of
+// that unrelated traffic too. Routing an individual call to a specific endpoint requires
+// a connection of its own, which would need the original dial options (TLS, auth) that
+// New is never given - action's own endpoint-addressed operation must supply that. On
+// exhaustion, the last attempt's error is wrapped with both cause and ErrRetriesExhausted
+// via %w, so callers can tell "gave up after retrying X" from any other failure via
+// errors.Is.
+func (c *Client) Retry(ctx context.Context, cause error, action func(ctx context.Context, endpoint string) error) error {
+	var lastErr error
+
+	for attempt := 0; attempt <= c.opt.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(c.backoff(attempt - 1)):
+			case <-ctx.Done():
+				return errors.Wrap(context.Cause(ctx), "retry abandoned")
+			}
+		}
+
+		if err := action(ctx, c.pickEndpoint()); err != nil {
+			lastErr = err
+			continue
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: %w: %w after %d attempts", ErrRetriesExhausted, cause, lastErr, c.opt.MaxRetries+1)
+}
+
+// session and mutex narrow *concurrency.Session and *concurrency.Mutex down to what
+// Locker needs, so tests can exercise Lock's retry/session-expiry interplay against a
+// fake instead of a real etcd session.
+type session interface {
+	Done() <-chan struct{}
+}
+
+type mutex interface {
+	Lock(ctx context.Context) error
+	Unlock(ctx context.Context) error
+}
+
+// Locker wraps a concurrency.Session/Mutex pair, surfacing session loss as ErrSessionLost
+// from Lock so a caller can bail out before pausing traffic, instead of pausing and only
+// then discovering the lock it thought it held is gone.
+type Locker struct {
+	client  *Client
+	session session
+	mutex   mutex
+}
+
+// NewLocker builds a Locker for key, bound to session. The session must already be
+// established; NewLocker does not create one.
+func NewLocker(client *Client, session *concurrency.Session, key string) *Locker {
+	return &Locker{
+		client:  client,
+		session: session,
+		mutex:   concurrency.NewMutex(session, key),
+	}
+}
+
+// errLockFailed labels Retry's bounded attempts at acquiring the mutex. It is deliberately
+// not ErrSessionLost: Retry wraps whatever exhausted the attempts into its own error
+// regardless of cause, and a plain transient failure exhausting its retries is not the
+// same thing as the session actually being gone. Lock only reports ErrSessionLost from
+// the explicit session.Done() checks below, which is the one genuine signal that the
+// session has expired.
+var errLockFailed = errors.New("failed to acquire failover lock")
+
+// Lock acquires the underlying mutex, retrying bounded attempts. If the session is found
+// to have expired, either before the attempt starts or once it completes, Lock returns
+// ErrSessionLost rather than a lock that might already be gone.
+func (l *Locker) Lock(ctx context.Context) error {
+	select {
+	case <-l.session.Done():
+		return ErrSessionLost
+	default:
+	}
+
+	if err := l.client.Retry(ctx, errLockFailed, func(ctx context.Context, endpoint string) error {
+		return l.mutex.Lock(ctx)
+	}); err != nil {
+		select {
+		case <-l.session.Done():
+			return ErrSessionLost
+		default:
+			return err
+		}
+	}
+
+	select {
+	case <-l.session.Done():
+		return ErrSessionLost
+	default:
+		return nil
+	}
+}
+
+// Unlock releases the underlying mutex.
+func (l *Locker) Unlock(ctx context.Context) error {
+	return l.mutex.Unlock(ctx)
+}
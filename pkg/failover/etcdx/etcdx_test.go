@@ -0,0 +1,194 @@
+package etcdx
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestClient(endpoints []string, healthy map[string]bool) *Client {
+	return &Client{
+		opt:       Options{}.withDefaults(),
+		endpoints: endpoints,
+		healthy:   healthy,
+	}
+}
+
+func TestPickEndpointSkipsUnhealthy(t *testing.T) {
+	c := newTestClient(
+		[]string{"a", "b", "c"},
+		map[string]bool{"a": false, "b": true, "c": true},
+	)
+
+	seen := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		seen[c.pickEndpoint()]++
+	}
+
+	if seen["a"] != 0 {
+		t.Fatalf("expected unhealthy endpoint a never picked, got %d picks", seen["a"])
+	}
+
+	if seen["b"] == 0 || seen["c"] == 0 {
+		t.Fatalf("expected both healthy endpoints picked, got %+v", seen)
+	}
+}
+
+func TestPickEndpointFallsBackWhenAllUnhealthy(t *testing.T) {
+	c := newTestClient(
+		[]string{"a", "b"},
+		map[string]bool{"a": false, "b": false},
+	)
+
+	if endpoint := c.pickEndpoint(); endpoint == "" {
+		t.Fatal("expected a fallback endpoint even when every endpoint looks unhealthy")
+	}
+}
+
+func TestRetrySucceedsAfterTransientFailures(t *testing.T) {
+	c := newTestClient([]string{"a", "b"}, map[string]bool{"a": true, "b": true})
+	c.opt.BackoffBase = time.Millisecond
+
+	attempts := 0
+	err := c.Retry(context.Background(), errors.New("test op"), func(ctx context.Context, endpoint string) error {
+		attempts++
+		if attempts < 2 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("expected eventual success, got %v", err)
+	}
+
+	if attempts != 2 {
+		t.Fatalf("expected exactly 2 attempts, got %d", attempts)
+	}
+}
+
+func TestRetryExhaustsAndWrapsCause(t *testing.T) {
+	c := newTestClient([]string{"a"}, map[string]bool{"a": true})
+	c.opt.BackoffBase = time.Millisecond
+	c.opt.MaxRetries = 2
+
+	cause := errors.New("clusterdata read failed")
+	attempts := 0
+	err := c.Retry(context.Background(), cause, func(ctx context.Context, endpoint string) error {
+		attempts++
+		return errors.New("boom")
+	})
+
+	if err == nil {
+		t.Fatal("expected an error once retries are exhausted")
+	}
+
+	if !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("expected errors.Is(err, ErrRetriesExhausted), got %v", err)
+	}
+
+	if !errors.Is(err, cause) {
+		t.Fatalf("expected errors.Is(err, cause), got %v", err)
+	}
+
+	if attempts != c.opt.MaxRetries+1 {
+		t.Fatalf("expected %d attempts, got %d", c.opt.MaxRetries+1, attempts)
+	}
+}
+
+// fakeSession lets tests control when a Locker's session looks expired without a real
+// etcd lease.
+type fakeSession struct {
+	done chan struct{}
+}
+
+func newFakeSession() *fakeSession {
+	return &fakeSession{done: make(chan struct{})}
+}
+
+func (s *fakeSession) Done() <-chan struct{} { return s.done }
+func (s *fakeSession) expire()               { close(s.done) }
+
+// fakeMutex lets tests control whether Lock's underlying mutex acquisition succeeds,
+// without a real etcd session.
+type fakeMutex struct {
+	lockErr error
+	onLock  func()
+	calls   int
+}
+
+func (m *fakeMutex) Lock(ctx context.Context) error {
+	m.calls++
+	if m.onLock != nil {
+		m.onLock()
+	}
+	return m.lockErr
+}
+
+func (m *fakeMutex) Unlock(ctx context.Context) error { return nil }
+
+func TestLockReturnsSessionLostWhenAlreadyExpired(t *testing.T) {
+	sess := newFakeSession()
+	sess.expire()
+	mux := &fakeMutex{}
+
+	l := &Locker{
+		client:  newTestClient([]string{"a"}, map[string]bool{"a": true}),
+		session: sess,
+		mutex:   mux,
+	}
+
+	if err := l.Lock(context.Background()); !errors.Is(err, ErrSessionLost) {
+		t.Fatalf("expected ErrSessionLost, got %v", err)
+	}
+
+	if mux.calls != 0 {
+		t.Fatalf("expected the mutex never to be attempted once the session is expired, got %d calls", mux.calls)
+	}
+}
+
+// TestLockRetryFailureIsNotReportedAsSessionLost guards against the bug where Retry's own
+// exhaustion error, labelled with a cause, got misread as session loss regardless of what
+// actually failed: a plain transient mutex failure exhausting its retries, with a healthy
+// session throughout, must not be reported as ErrSessionLost.
+func TestLockRetryFailureIsNotReportedAsSessionLost(t *testing.T) {
+	sess := newFakeSession()
+	mux := &fakeMutex{lockErr: errors.New("transient: connection refused")}
+
+	c := newTestClient([]string{"a"}, map[string]bool{"a": true})
+	c.opt.BackoffBase = time.Millisecond
+	c.opt.MaxRetries = 2
+
+	l := &Locker{client: c, session: sess, mutex: mux}
+
+	err := l.Lock(context.Background())
+	if err == nil {
+		t.Fatal("expected an error once retries are exhausted")
+	}
+
+	if errors.Is(err, ErrSessionLost) {
+		t.Fatalf("expected a plain retry exhaustion not to be reported as ErrSessionLost, got %v", err)
+	}
+
+	if !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("expected errors.Is(err, ErrRetriesExhausted), got %v", err)
+	}
+
+	if mux.calls != c.opt.MaxRetries+1 {
+		t.Fatalf("expected %d attempts, got %d", c.opt.MaxRetries+1, mux.calls)
+	}
+}
+
+func TestLockReturnsSessionLostWhenSessionExpiresDuringAcquire(t *testing.T) {
+	sess := newFakeSession()
+	mux := &fakeMutex{onLock: sess.expire}
+
+	c := newTestClient([]string{"a"}, map[string]bool{"a": true})
+
+	l := &Locker{client: c, session: sess, mutex: mux}
+
+	if err := l.Lock(context.Background()); !errors.Is(err, ErrSessionLost) {
+		t.Fatalf("expected ErrSessionLost once the session expired during a successful acquisition, got %v", err)
+	}
+}
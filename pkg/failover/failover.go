@@ -7,10 +7,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gocardless/stolon-pgbouncer/pkg/etcd"
+	"github.com/gocardless/stolon-pgbouncer/pkg/failover/chaos"
+	"github.com/gocardless/stolon-pgbouncer/pkg/failover/etcdx"
 	"github.com/gocardless/stolon-pgbouncer/pkg/stolon"
 	"github.com/gocardless/stolon-pgbouncer/pkg/streams"
 
@@ -23,6 +27,7 @@ import (
 type Failover struct {
 	logger    kitlog.Logger
 	client    *clientv3.Client
+	etcd      *etcdx.Client
 	clients   map[string]FailoverClient
 	stolonctl stolon.Stolonctl
 	locker    locker
@@ -35,28 +40,55 @@ type FailoverOptions struct {
 	LockTimeout        time.Duration
 	PauseTimeout       time.Duration
 	PauseExpiry        time.Duration
+	PauseQuorum        int
 	ResumeTimeout      time.Duration
 	StolonctlTimeout   time.Duration
+
+	// RecoveryPhaseTimeout bounds how long the recovery watchdog will wait for the next
+	// RecoveryPhase to be observed before concluding recovery has stalled. Defaults to
+	// DefaultRecoveryPhaseTimeout when unset. This replaces a single PauseExpiry
+	// deadline, which aborted a failover that was still making progress (new master
+	// elected, sync standbys catching up) just because stolon took a little over the
+	// deadline to finish promoting the standby.
+	RecoveryPhaseTimeout time.Duration
+
+	// Failpoints, when set, lets integration tests inject faults at named step
+	// boundaries (e.g. "pause.pre", "failkeeper.pre"). It is always nil in production,
+	// where every Failpoints.Hit call is a no-op.
+	Failpoints chaos.Failpoints
+
+	// Etcdx configures the health-aware endpoint selection and retry behaviour used for
+	// lock acquisition and clusterdata reads. Zero value applies etcdx's defaults.
+	Etcdx etcdx.Options
 }
 
+// DefaultRecoveryPhaseTimeout is used when FailoverOptions.RecoveryPhaseTimeout is unset.
+const DefaultRecoveryPhaseTimeout = 5 * time.Second
+
 type locker interface {
 	Lock(context.Context) error
 	Unlock(context.Context) error
 }
 
-func NewFailover(logger kitlog.Logger, client *clientv3.Client, clients map[string]FailoverClient, stolonctl stolon.Stolonctl, opt FailoverOptions) *Failover {
-	session, _ := concurrency.NewSession(client)
+func NewFailover(ctx context.Context, logger kitlog.Logger, client *clientv3.Client, clients map[string]FailoverClient, stolonctl stolon.Stolonctl, opt FailoverOptions) (*Failover, error) {
+	session, err := concurrency.NewSession(client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to establish etcd session")
+	}
+
+	etcdClient := etcdx.New(ctx, client, opt.Etcdx)
 
 	return &Failover{
 		logger:    logger,
 		client:    client,
+		etcd:      etcdClient,
 		clients:   clients,
 		stolonctl: stolonctl,
 		opt:       opt,
-		locker: concurrency.NewMutex(
-			session, fmt.Sprintf("%s/failover", opt.ClusterdataKey),
+		locker: etcdx.NewLocker(
+			etcdClient, session, fmt.Sprintf("%s/failover", opt.ClusterdataKey),
 		),
-	}
+	}, nil
 }
 
 // Run triggers the failover process. We model this as a Pipeline of steps, where each
@@ -68,19 +100,37 @@ func NewFailover(logger kitlog.Logger, client *clientv3.Client, clients map[stri
 // present.
 func (f *Failover) Run(ctx context.Context, deferCtx context.Context) error {
 	return Pipeline(
-		Step(f.HealthCheckClients),
-		Step(f.AcquireLock).Defer(f.ReleaseLock),
-		Step(f.Pause).Defer(f.Resume),
-		Step(f.Failkeeper),
+		Step(f.withFailpoints("health_check", f.HealthCheckClients)),
+		Step(f.withFailpoints("acquire_lock", f.AcquireLock)).Defer(f.withFailpoints("release_lock", f.ReleaseLock)),
+		Step(f.withFailpoints("pause", f.Pause)).Defer(f.withFailpoints("resume", f.Resume)),
+		Step(f.withFailpoints("failkeeper", f.Failkeeper)),
 	)(
 		ctx, deferCtx,
 	)
 }
 
+// withFailpoints wraps run with chaos failpoint hooks named "<name>.pre" and
+// "<name>.post", so chaos tests can inject a fault at a step boundary without a single
+// test conditional appearing inside the step's own implementation. With opt.Failpoints
+// unset, as it always is in production, both hooks are no-ops.
+func (f *Failover) withFailpoints(name string, run StepFunc) StepFunc {
+	return func(ctx context.Context) error {
+		if err := f.opt.Failpoints.Hit(ctx, name+".pre"); err != nil {
+			return err
+		}
+
+		if err := run(ctx); err != nil {
+			return err
+		}
+
+		return f.opt.Failpoints.Hit(ctx, name+".post")
+	}
+}
+
 func (f *Failover) HealthCheckClients(ctx context.Context) error {
 	f.logger.Log("event", "clients.health_check", "msg", "health checking all clients")
 	for endpoint, client := range f.clients {
-		ctx, cancel := context.WithTimeout(ctx, f.opt.HealthCheckTimeout)
+		ctx, cancel := context.WithTimeoutCause(ctx, f.opt.HealthCheckTimeout, ErrHealthCheckTimeout)
 		defer cancel()
 
 		resp, err := client.HealthCheck(ctx, &Empty{})
@@ -98,15 +148,26 @@ func (f *Failover) HealthCheckClients(ctx context.Context) error {
 
 func (f *Failover) AcquireLock(ctx context.Context) error {
 	f.logger.Log("event", "etcd.lock.acquire", "msg", "acquiring failover lock in etcd")
-	ctx, cancel := context.WithTimeout(ctx, f.opt.LockTimeout)
+	ctx, cancel := context.WithTimeoutCause(ctx, f.opt.LockTimeout, ErrLockTimeout)
 	defer cancel()
 
-	return f.locker.Lock(ctx)
+	if err := f.locker.Lock(ctx); err != nil {
+		if errors.Is(err, etcdx.ErrSessionLost) {
+			return errors.Wrap(err, "etcd session lost acquiring failover lock, bailing out before pausing traffic")
+		}
+
+		return err
+	}
+
+	return nil
 }
 
+// ReleaseLock is deferred behind AcquireLock, so ctx here is the pipeline's deferCtx: its
+// cause tells us *why* we're cleaning up, e.g. ErrPauseExpired rather than a generic
+// "context canceled", which is worth logging alongside the release itself.
 func (f *Failover) ReleaseLock(ctx context.Context) error {
-	f.logger.Log("event", "etcd.lock.release", "msg", "releasing failover lock in etcd")
-	ctx, cancel := context.WithTimeout(ctx, f.opt.LockTimeout)
+	f.logger.Log("event", "etcd.lock.release", "msg", "releasing failover lock in etcd", "cause", causeOrNone(ctx))
+	ctx, cancel := context.WithTimeoutCause(ctx, f.opt.LockTimeout, ErrLockTimeout)
 	defer cancel()
 
 	return f.locker.Unlock(ctx)
@@ -118,10 +179,10 @@ func (f *Failover) Pause(ctx context.Context) error {
 
 	// Allow an additional second for network round-trip. We should have terminated this
 	// request far before this context is expired.
-	ctx, cancel := context.WithTimeout(ctx, f.opt.PauseExpiry+time.Second)
+	ctx, cancel := context.WithTimeoutCause(ctx, f.opt.PauseExpiry+time.Second, ErrPauseExpired)
 	defer cancel()
 
-	err := f.EachClient(logger, func(endpoint string, client FailoverClient) error {
+	_, err := f.EachClient(logger, QuorumMustSucceed(f.quorumSize()), "pause", func(endpoint string, client FailoverClient) error {
 		_, err := client.Pause(
 			ctx, &PauseRequest{
 				Timeout: int64(f.opt.PauseTimeout),
@@ -133,59 +194,219 @@ func (f *Failover) Pause(ctx context.Context) error {
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to pause pgbouncers")
+		if cause := context.Cause(ctx); cause != nil {
+			return fmt.Errorf("failed to pause pgbouncers: %w: %w", cause, err)
+		}
+
+		return errors.Wrap(err, "failed to pause pgbouncers")
 	}
 
 	return nil
 }
 
+// quorumSize returns the number of pgbouncer clients that must successfully pause
+// before we proceed with the failover. Operators can pin this via PauseQuorum; when
+// unset we fall back to a simple majority, so with three or more clients a single dead
+// pgbouncer host does not block a failover. With one or two clients the majority is
+// every client.
+func (f *Failover) quorumSize() int {
+	if f.opt.PauseQuorum > 0 {
+		return f.opt.PauseQuorum
+	}
+
+	return len(f.clients)/2 + 1
+}
+
+// Resume is deferred behind Pause, so ctx here is the pipeline's deferCtx: its cause
+// tells us why we're resuming, e.g. ErrRecoveryStalled once Failkeeper's recovery
+// watchdog has given up, which is worth logging alongside the resume itself.
 func (f *Failover) Resume(ctx context.Context) error {
 	logger := kitlog.With(f.logger, "event", "clients.pgbouncer.resume")
-	logger.Log("msg", "requesting all pgbouncers resume")
+	logger.Log("msg", "requesting all pgbouncers resume", "cause", causeOrNone(ctx))
 
-	ctx, cancel := context.WithTimeout(ctx, f.opt.ResumeTimeout)
+	ctx, cancel := context.WithTimeoutCause(ctx, f.opt.ResumeTimeout, ErrResumeTimeout)
 	defer cancel()
 
-	err := f.EachClient(logger, func(endpoint string, client FailoverClient) error {
+	// BestEffort, as we must release every reachable bouncer even when a failover only
+	// partly succeeded: leaving a healthy pgbouncer paused because another one is down
+	// is worse than resuming everything we can reach.
+	results, _ := f.EachClient(logger, BestEffort(), "resume", func(endpoint string, client FailoverClient) error {
 		_, err := client.Resume(ctx, &Empty{})
 		return err
 	})
 
-	if err != nil {
-		return fmt.Errorf("failed to resume pgbouncers")
+	if failures := results.Failures(); len(failures) > 0 {
+		logger.Log(
+			"event", "clients.pgbouncer.resume.partial", "failed", len(failures),
+			"msg", "some pgbouncers failed to resume, continuing as failover must release every reachable bouncer",
+		)
 	}
 
 	return nil
 }
 
+// ClientPolicy determines how EachClient interprets the results of running an action
+// against every failover client, deciding whether the overall operation succeeded.
+type ClientPolicy interface {
+	Evaluate(ClientResults) error
+}
+
+type allMustSucceedPolicy struct{}
+
+// AllMustSucceed requires every client to succeed, matching the historical EachClient
+// behaviour: a single failing endpoint fails the whole operation.
+func AllMustSucceed() ClientPolicy { return allMustSucceedPolicy{} }
+
+func (allMustSucceedPolicy) Evaluate(results ClientResults) error {
+	if failures := results.Failures(); len(failures) > 0 {
+		return errors.Wrapf(failures, "%d/%d clients failed", len(failures), len(results))
+	}
+
+	return nil
+}
+
+type quorumMustSucceedPolicy struct {
+	n int
+}
+
+// QuorumMustSucceed requires at least n clients to succeed, so a minority of dead or
+// unreachable pgbouncers doesn't block the whole failover.
+func QuorumMustSucceed(n int) ClientPolicy { return quorumMustSucceedPolicy{n: n} }
+
+func (p quorumMustSucceedPolicy) Evaluate(results ClientResults) error {
+	failures := results.Failures()
+	succeeded := len(results) - len(failures)
+
+	if succeeded < p.n {
+		return errors.Wrapf(
+			failures, "only %d/%d clients succeeded, quorum of %d required",
+			succeeded, len(results), p.n,
+		)
+	}
+
+	return nil
+}
+
+type bestEffortPolicy struct{}
+
+// BestEffort never fails, regardless of how many clients errored: callers are expected
+// to inspect the returned ClientResults themselves.
+func BestEffort() ClientPolicy { return bestEffortPolicy{} }
+
+func (bestEffortPolicy) Evaluate(ClientResults) error { return nil }
+
+// ClientResult captures the outcome of running an EachClient action against a single
+// endpoint.
+type ClientResult struct {
+	Endpoint string
+	Err      error
+}
+
+// ClientResults aggregates every ClientResult from an EachClient call, so a policy (or
+// its caller) can reason about each endpoint's outcome rather than racing to record only
+// the last error.
+type ClientResults []ClientResult
+
+// Failures returns the subset of results that errored.
+func (rs ClientResults) Failures() ClientResults {
+	failures := make(ClientResults, 0, len(rs))
+	for _, result := range rs {
+		if result.Err != nil {
+			failures = append(failures, result)
+		}
+	}
+
+	return failures
+}
+
+// Error implements error so ClientResults can be wrapped directly into the error
+// returned from a ClientPolicy.
+func (rs ClientResults) Error() string {
+	msgs := make([]string, 0, len(rs))
+	for _, result := range rs {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", result.Endpoint, result.Err))
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
 // EachClient provides a helper to perform actions on all the failover clients, in
 // parallel. For some operations where there is a penalty for extended running time (such
 // as pause) it's important that each request occurs in parallel.
-func (f *Failover) EachClient(logger kitlog.Logger, action func(string, FailoverClient) error) (result error) {
+//
+// Each client's action runs isolated behind a recover, in the spirit of Kubernetes'
+// utilruntime.HandleCrash: a single panicking response deserializer or nil map access
+// must never be allowed to crash the orchestrator mid-failover. The supplied policy then
+// decides, from the full set of per-endpoint results, whether EachClient as a whole
+// succeeded.
+//
+// step identifies the calling step (e.g. "pause", "resume") so a chaos Kill failpoint
+// registered against it can drop a specific endpoint's connection without EachClient's
+// callers needing to know anything about chaos testing themselves.
+func (f *Failover) EachClient(logger kitlog.Logger, policy ClientPolicy, step string, action func(string, FailoverClient) error) (ClientResults, error) {
+	resultsCh := make(chan ClientResult, len(f.clients))
+	killed, _ := f.opt.Failpoints.Killed(step)
+
 	var wg sync.WaitGroup
 	for endpoint, client := range f.clients {
 		wg.Add(1)
 
 		go func(endpoint string, client FailoverClient) {
-			defer func(begin time.Time) {
-				logger.Log("endpoint", endpoint, "elapsed", time.Since(begin).Seconds())
-				wg.Done()
-			}(time.Now())
+			defer wg.Done()
+
+			if endpoint == killed {
+				err := chaos.ErrKilled(endpoint)
+				logger.Log("endpoint", endpoint, "error", err.Error(), "event", "chaos.kill")
+				resultsCh <- ClientResult{Endpoint: endpoint, Err: err}
+				return
+			}
 
-			if err := action(endpoint, client); err != nil {
+			begin := time.Now()
+			err := f.runClientAction(logger, endpoint, action, client)
+			logger.Log("endpoint", endpoint, "elapsed", time.Since(begin).Seconds())
+
+			if err != nil {
 				logger.Log("endpoint", endpoint, "error", err.Error())
-				result = err
 			}
+
+			resultsCh <- ClientResult{Endpoint: endpoint, Err: err}
 		}(endpoint, client)
 	}
 
 	wg.Wait()
-	return result
+	close(resultsCh)
+
+	results := make(ClientResults, 0, len(f.clients))
+	for result := range resultsCh {
+		results = append(results, result)
+	}
+
+	return results, policy.Evaluate(results)
+}
+
+// runClientAction recovers any panic raised by action, turning it into a structured
+// error scoped to the offending endpoint instead of crashing every other in-flight
+// goroutine along with it.
+func (f *Failover) runClientAction(logger kitlog.Logger, endpoint string, action func(string, FailoverClient) error, client FailoverClient) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Log("endpoint", endpoint, "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("client %s panicked: %v", endpoint, r)
+		}
+	}()
+
+	return action(endpoint, client)
 }
 
 // Failkeeper uses stolonctl to mark the current primary keeper as failed
 func (f *Failover) Failkeeper(ctx context.Context) error {
-	clusterdata, err := stolon.GetClusterdata(ctx, f.client, f.opt.ClusterdataKey)
+	var clusterdata *stolon.Clusterdata
+
+	err := f.etcd.Retry(ctx, errors.New("failed to read clusterdata"), func(ctx context.Context, endpoint string) error {
+		var err error
+		clusterdata, err = stolon.GetClusterdata(ctx, f.client, f.opt.ClusterdataKey)
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -196,7 +417,7 @@ func (f *Failover) Failkeeper(ctx context.Context) error {
 		return errors.New("could not identify master keeper")
 	}
 
-	timeoutCtx, cancel := context.WithTimeout(ctx, f.opt.StolonctlTimeout)
+	timeoutCtx, cancel := context.WithTimeoutCause(ctx, f.opt.StolonctlTimeout, ErrStolonctlTimeout)
 	defer cancel()
 
 	cmd := f.stolonctl.CommandContext(timeoutCtx, "failkeeper", masterKeeperUID)
@@ -207,23 +428,151 @@ func (f *Failover) Failkeeper(ctx context.Context) error {
 		return errors.Wrap(err, "failed to run stolonctl failkeeper")
 	}
 
+	recoverCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	stall := func(phase RecoveryPhase) { cancel(ErrRecoveryStalled{Phase: phase}) }
+
 	select {
-	case <-time.After(f.opt.PauseExpiry):
-		return fmt.Errorf("timed out waiting for successful recovery")
-	case newMaster := <-f.NotifyRecovered(ctx, f.logger, master):
+	case <-recoverCtx.Done():
+		return errors.Wrap(context.Cause(recoverCtx), "failed waiting for successful recovery")
+	case newMaster := <-f.NotifyRecovered(recoverCtx, f.logger, master, stall):
 		f.logger.Log("msg", "cluster successfully recovered", "master", newMaster)
 	}
 
 	return nil
 }
 
+// RecoveryPhase names a stage of stolon's post-failover recovery, in the order we expect
+// the recovery watchdog to observe them.
+type RecoveryPhase int
+
+const (
+	_ RecoveryPhase = iota
+	PhaseMasterChanged
+	PhaseMasterHealthy
+	PhaseStandbysHealthy
+)
+
+func (p RecoveryPhase) String() string {
+	switch p {
+	case PhaseMasterChanged:
+		return "master_changed"
+	case PhaseMasterHealthy:
+		return "master_healthy"
+	case PhaseStandbysHealthy:
+		return "standbys_healthy"
+	default:
+		return "none"
+	}
+}
+
+// ErrRecoveryStalled is returned when the recovery watchdog observes no progress past
+// Phase within the configured RecoveryPhaseTimeout, naming the phase so operators can
+// tell which timeout to tune instead of guessing at a single blanket deadline. It
+// unwraps to ErrRecoveryTimeout so existing cause matching still recognises "the
+// recovery watchdog fired".
+type ErrRecoveryStalled struct {
+	Phase RecoveryPhase
+}
+
+func (e ErrRecoveryStalled) Error() string {
+	return fmt.Sprintf("recovery watchdog: no progress past phase %q within the configured timeout", e.Phase)
+}
+
+func (e ErrRecoveryStalled) Unwrap() error {
+	return ErrRecoveryTimeout
+}
+
+// recoveryWatchdog tracks the furthest RecoveryPhase observed so far and calls stall with
+// it once phaseTimeout passes with no further progress. Unlike a channel send, progress is
+// recorded under a mutex, so a slow or not-yet-scheduled watchdog can never cause a
+// reported phase to be silently dropped the way a non-blocking channel send would.
+type recoveryWatchdog struct {
+	logger       kitlog.Logger
+	phaseTimeout time.Duration
+	stall        func(RecoveryPhase)
+
+	mu      sync.Mutex
+	phase   RecoveryPhase
+	timer   *time.Timer
+	stopped bool
+}
+
+// newRecoveryWatchdog starts a watchdog that calls stall with the furthest phase observed
+// if phaseTimeout elapses without progress. Call stop once recovery has finished, whether
+// it succeeded or not, so the watchdog's timer doesn't fire after the fact.
+func newRecoveryWatchdog(logger kitlog.Logger, phaseTimeout time.Duration, stall func(RecoveryPhase)) *recoveryWatchdog {
+	w := &recoveryWatchdog{logger: logger, phaseTimeout: phaseTimeout, stall: stall}
+	w.timer = time.AfterFunc(phaseTimeout, w.fire)
+	return w
+}
+
+// progress records that phase has been reached, resetting the stall timer if it is further
+// than any phase reached so far. A phase reached out of order or repeated leaves both the
+// watchdog's notion of how far recovery has got and the stall timer untouched.
+func (w *recoveryWatchdog) progress(phase RecoveryPhase) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stopped {
+		return
+	}
+
+	if phase > w.phase {
+		w.phase = phase
+		w.logger.Log("event", "recovery.progress", "phase", phase.String())
+		w.timer.Reset(w.phaseTimeout)
+	}
+}
+
+// fire is called once phaseTimeout elapses with no advancing call to progress. It is a no-op once
+// the watchdog has been stopped, so a timer that was already in flight when stop ran can
+// never call stall after the fact.
+func (w *recoveryWatchdog) fire() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stopped {
+		return
+	}
+
+	w.stopped = true
+	w.logger.Log("event", "recovery.stalled", "phase", w.phase.String(), "msg", "no recovery progress observed within timeout")
+	w.stall(w.phase)
+}
+
+// stop disarms the watchdog so it will never call stall, used once recovery has finished
+// by whatever means (success or a context already cancelled some other way).
+func (w *recoveryWatchdog) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stopped = true
+	w.timer.Stop()
+}
+
 // NotifyRecovered will return a channel that receives the new master DB only once it is
 // healthy and available for writes. We determine this by checking the new master and all
 // its sync nodes are healthy.
-func (f *Failover) NotifyRecovered(ctx context.Context, logger kitlog.Logger, oldMaster stolon.DB) chan stolon.DB {
+//
+// Each clusterdata revision that advances the recovery state machine - master changed,
+// then master healthy, then all sync standbys healthy - is reported to a watchdog, which
+// resets a per-phase timeout on every such advance. Only a period of no
+// observed progress causes stall to be invoked, naming the phase it was waiting on, so a
+// slow-but-progressing failover is never aborted just for running a little over a single
+// blanket deadline.
+func (f *Failover) NotifyRecovered(ctx context.Context, logger kitlog.Logger, oldMaster stolon.DB, stall func(RecoveryPhase)) chan stolon.DB {
 	logger = kitlog.With(logger, "key", f.opt.ClusterdataKey)
 	logger.Log("msg", "waiting for stolon to report master change")
 
+	phaseTimeout := f.opt.RecoveryPhaseTimeout
+	if phaseTimeout == 0 {
+		phaseTimeout = DefaultRecoveryPhaseTimeout
+	}
+
+	watchdog := newRecoveryWatchdog(logger, phaseTimeout, stall)
+
 	kvs, _ := etcd.NewStream(
 		f.logger,
 		f.client,
@@ -239,7 +588,18 @@ func (f *Failover) NotifyRecovered(ctx context.Context, logger kitlog.Logger, ol
 
 	notify := make(chan stolon.DB)
 	go func() {
+		defer watchdog.stop()
+
+		first := true
 		for kv := range kvs {
+			if first {
+				first = false
+				if err := f.opt.Failpoints.Hit(ctx, "notify_recovered.first_event"); err != nil {
+					logger.Log("event", "chaos.delay", "error", err, "msg", "dropping first clusterdata event")
+					continue
+				}
+			}
+
 			if string(kv.Key) != f.opt.ClusterdataKey {
 				continue
 			}
@@ -255,11 +615,13 @@ func (f *Failover) NotifyRecovered(ctx context.Context, logger kitlog.Logger, ol
 				logger.Log("event", "pending_failover", "master", master, "msg", "master has not changed nodes")
 				continue
 			}
+			watchdog.progress(PhaseMasterChanged)
 
 			if !master.Status.Healthy {
 				logger.Log("event", "master.unhealthy", "master", master, "msg", "new master is unhealthy")
 				continue
 			}
+			watchdog.progress(PhaseMasterHealthy)
 
 			anyUnhealthyStandbys := false
 			for _, standby := range clusterdata.SynchronousStandbys() {
@@ -272,10 +634,18 @@ func (f *Failover) NotifyRecovered(ctx context.Context, logger kitlog.Logger, ol
 			if anyUnhealthyStandbys {
 				continue
 			}
+			watchdog.progress(PhaseStandbysHealthy)
 
 			logger.Log("event", "healthy", "master", master, "msg", "master is available for writes")
+
+			select {
+			case notify <- master:
+			case <-ctx.Done():
+			}
+
+			return
 		}
 	}()
 
 	return notify
-}
\ No newline at end of file
+}
@@ -0,0 +1,317 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gocardless/stolon-pgbouncer/pkg/failover/chaos"
+
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// newTestFailover builds a Failover with just enough state to exercise EachClient: no
+// etcd session or stolonctl is required, since neither is reached by the cases below.
+func newTestFailover(endpoints []string, failpoints chaos.Failpoints) *Failover {
+	clients := make(map[string]FailoverClient, len(endpoints))
+	for _, endpoint := range endpoints {
+		clients[endpoint] = nil // never dereferenced; the actions below ignore it
+	}
+
+	return &Failover{
+		logger:  kitlog.NewNopLogger(),
+		clients: clients,
+		opt:     FailoverOptions{Failpoints: failpoints},
+	}
+}
+
+// TestEachClientPoliciesAndFailpoints runs EachClient under a set of combinations of
+// ClientPolicy and chaos.Failpoint, asserting that a quorum or
+// best-effort pause/resume reaches a consistent outcome even when one endpoint panics,
+// errors or is killed outright.
+func TestEachClientPoliciesAndFailpoints(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	cases := []struct {
+		name      string
+		endpoints []string
+		policy    ClientPolicy
+		failpoint chaos.Failpoint
+		wantErr   bool
+	}{
+		{
+			name:      "all succeed under AllMustSucceed",
+			endpoints: []string{"a", "b", "c"},
+			policy:    AllMustSucceed(),
+			wantErr:   false,
+		},
+		{
+			name:      "one panic fails AllMustSucceed but does not crash",
+			endpoints: []string{"a", "b", "c"},
+			policy:    AllMustSucceed(),
+			failpoint: chaos.Panic("nil map access"),
+			wantErr:   true,
+		},
+		{
+			name:      "one killed endpoint still meets quorum",
+			endpoints: []string{"a", "b", "c"},
+			policy:    QuorumMustSucceed(2),
+			failpoint: chaos.Kill("a"),
+			wantErr:   false,
+		},
+		{
+			name:      "a killed endpoint out of two misses quorum of two",
+			endpoints: []string{"a", "b"},
+			policy:    QuorumMustSucceed(2),
+			failpoint: chaos.Kill("a"),
+			wantErr:   true,
+		},
+		{
+			name:      "BestEffort never fails regardless of injected error",
+			endpoints: []string{"a", "b"},
+			policy:    BestEffort(),
+			failpoint: chaos.Return(errBoom),
+			wantErr:   false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			failpoints := chaos.Failpoints{}
+			if _, ok := tc.failpoint.Killed(); ok {
+				failpoints["step"] = tc.failpoint
+			}
+
+			f := newTestFailover(tc.endpoints, failpoints)
+
+			action := func(endpoint string, client FailoverClient) error {
+				if _, ok := tc.failpoint.Killed(); !ok && endpoint == tc.endpoints[0] {
+					if err := tc.failpoint.Hit(context.Background()); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			}
+
+			results, err := f.EachClient(f.logger, tc.policy, "step", action)
+
+			if tc.wantErr && err == nil {
+				t.Fatalf("expected an error, got none; results: %+v", results)
+			}
+
+			if !tc.wantErr && err != nil {
+				t.Fatalf("expected no error, got %v; results: %+v", err, results)
+			}
+
+			if len(results) != len(tc.endpoints) {
+				t.Fatalf("expected %d results, got %d", len(tc.endpoints), len(results))
+			}
+		})
+	}
+}
+
+// fakeLocker records Lock/Unlock calls and their ordering, standing in for etcdx.Locker
+// so Run's AcquireLock/ReleaseLock pipeline step can be exercised without a real etcd
+// session.
+type fakeLocker struct {
+	mu          sync.Mutex
+	lockErr     error
+	unlockErr   error
+	lockCalls   int
+	unlockCalls int
+	locked      bool
+}
+
+func (l *fakeLocker) Lock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lockCalls++
+	if l.lockErr != nil {
+		return l.lockErr
+	}
+
+	l.locked = true
+	return nil
+}
+
+func (l *fakeLocker) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.unlockCalls++
+	if l.unlockErr != nil {
+		return l.unlockErr
+	}
+
+	l.locked = false
+	return nil
+}
+
+// TestRunReleasesLockWhenStepsFail is a Run()-level test driving the pipeline through
+// AcquireLock/ReleaseLock with a fakeLocker and chaos failpoints at each step boundary.
+//
+// It stops short of reaching Pause or Failkeeper succeeding: Pause always fails here
+// because FailoverClient is a generated gRPC client type with no definition in this
+// tree to fake against, and Failkeeper shells out via stolon.Stolonctl to a real
+// stolonctl binary and reads clusterdata from a live etcd cluster. What's tested instead
+// is the property that matters regardless of how far the pipeline gets: however early
+// Run stops, AcquireLock's deferred ReleaseLock only runs - and only releases - a lock
+// that was actually acquired.
+func TestRunReleasesLockWhenStepsFail(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	cases := []struct {
+		name            string
+		failpoints      chaos.Failpoints
+		lockErr         error
+		wantLockCalls   int
+		wantUnlockCalls int
+	}{
+		{
+			name:            "health check failure never attempts the lock",
+			failpoints:      chaos.Failpoints{"health_check.pre": chaos.Return(errBoom)},
+			wantLockCalls:   0,
+			wantUnlockCalls: 0,
+		},
+		{
+			name:            "lock failure has nothing to release",
+			lockErr:         errBoom,
+			wantLockCalls:   1,
+			wantUnlockCalls: 0,
+		},
+		{
+			name:            "pause failing with no reachable clients still releases the acquired lock",
+			wantLockCalls:   1,
+			wantUnlockCalls: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			locker := &fakeLocker{lockErr: tc.lockErr}
+
+			f := &Failover{
+				logger:  kitlog.NewNopLogger(),
+				clients: map[string]FailoverClient{}, // no reachable clients: Pause always fails quorum
+				locker:  locker,
+				opt: FailoverOptions{
+					Failpoints:    tc.failpoints,
+					LockTimeout:   time.Second,
+					ResumeTimeout: time.Second,
+				},
+			}
+
+			err := f.Run(context.Background(), context.Background())
+			if err == nil {
+				t.Fatal("expected Run to fail before reaching a real FailoverClient or stolonctl call")
+			}
+
+			locker.mu.Lock()
+			defer locker.mu.Unlock()
+
+			if locker.lockCalls != tc.wantLockCalls {
+				t.Fatalf("expected %d Lock calls, got %d", tc.wantLockCalls, locker.lockCalls)
+			}
+
+			if locker.unlockCalls != tc.wantUnlockCalls {
+				t.Fatalf("expected %d Unlock calls, got %d", tc.wantUnlockCalls, locker.unlockCalls)
+			}
+
+			if locker.locked {
+				t.Fatal("expected the lock to never be left held once Run returned")
+			}
+		})
+	}
+}
+
+// TestRecoveryWatchdogStallsWithoutProgress asserts that a watchdog which never observes
+// progress calls stall, naming the phase it started at.
+func TestRecoveryWatchdogStallsWithoutProgress(t *testing.T) {
+	var mu sync.Mutex
+	var stalled RecoveryPhase
+	stalledCh := make(chan struct{})
+
+	w := newRecoveryWatchdog(kitlog.NewNopLogger(), 10*time.Millisecond, func(phase RecoveryPhase) {
+		mu.Lock()
+		stalled = phase
+		mu.Unlock()
+		close(stalledCh)
+	})
+	defer w.stop()
+
+	select {
+	case <-stalledCh:
+	case <-time.After(time.Second):
+		t.Fatal("expected stall to be called, timed out waiting")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if stalled != RecoveryPhase(0) {
+		t.Fatalf("expected stall to report no phase reached, got %s", stalled)
+	}
+}
+
+// TestRecoveryWatchdogResetsOnProgress asserts that advancing to a new phase, faster than
+// phaseTimeout each time, prevents stall from firing even once more than phaseTimeout has
+// passed in all, and that the furthest phase observed is the one eventually reported once
+// progress does stop.
+func TestRecoveryWatchdogResetsOnProgress(t *testing.T) {
+	var mu sync.Mutex
+	var stalled RecoveryPhase
+	stalledCh := make(chan struct{})
+
+	w := newRecoveryWatchdog(kitlog.NewNopLogger(), 50*time.Millisecond, func(phase RecoveryPhase) {
+		mu.Lock()
+		stalled = phase
+		mu.Unlock()
+		close(stalledCh)
+	})
+	defer w.stop()
+
+	for _, phase := range []RecoveryPhase{PhaseMasterChanged, PhaseMasterHealthy} {
+		time.Sleep(30 * time.Millisecond)
+		w.progress(phase)
+	}
+
+	select {
+	case <-stalledCh:
+		t.Fatal("expected stall not to fire while progress kept resetting the timer")
+	default:
+	}
+
+	w.progress(PhaseStandbysHealthy)
+
+	select {
+	case <-stalledCh:
+	case <-time.After(time.Second):
+		t.Fatal("expected stall to be called once progress stopped, timed out waiting")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if stalled != PhaseStandbysHealthy {
+		t.Fatalf("expected stall to report the furthest phase reached, got %s", stalled)
+	}
+}
+
+// TestRecoveryWatchdogStopPreventsStall asserts that stop disarms the watchdog, so a
+// timer already in flight when recovery finished never calls stall after the fact.
+func TestRecoveryWatchdogStopPreventsStall(t *testing.T) {
+	called := make(chan struct{})
+
+	w := newRecoveryWatchdog(kitlog.NewNopLogger(), 10*time.Millisecond, func(phase RecoveryPhase) {
+		close(called)
+	})
+	w.stop()
+
+	select {
+	case <-called:
+		t.Fatal("expected stall never to be called once the watchdog was stopped")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,101 @@
+package failover
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Cause values label *why* a step, or the deferCtx passed to its deferred cleanup, was
+// interrupted. They let a deferred step such as ReleaseLock or Resume log and act on the
+// real reason it's being asked to clean up, rather than a generic "context canceled".
+var (
+	ErrHealthCheckTimeout = errors.New("timed out health checking pgbouncer clients")
+	ErrLockTimeout        = errors.New("timed out waiting to acquire failover lock")
+	ErrPauseExpired       = errors.New("pause phase expired before recovery completed")
+	ErrResumeTimeout      = errors.New("timed out resuming pgbouncer clients")
+	ErrStolonctlTimeout   = errors.New("timed out running stolonctl failkeeper")
+	ErrRecoveryTimeout    = errors.New("recovery watchdog timed out waiting for progress")
+)
+
+// StepFunc is the signature of both a pipeline step and its deferred cleanup.
+type StepFunc func(ctx context.Context) error
+
+// PipelineStep pairs a primary action with the cleanup that must run once the pipeline
+// has finished, regardless of where it stopped.
+type PipelineStep struct {
+	run      StepFunc
+	deferred StepFunc
+}
+
+// Step begins a pipeline step. Call Defer on the result to schedule a cleanup action,
+// mirroring how a Go defer is scheduled immediately after the resource it releases is
+// acquired.
+func Step(run StepFunc) *PipelineStep {
+	return &PipelineStep{run: run}
+}
+
+// Defer attaches a cleanup action that Pipeline will run, against deferCtx, once the
+// pipeline has stopped, provided this step's own run succeeded.
+func (s *PipelineStep) Defer(fn StepFunc) *PipelineStep {
+	s.deferred = fn
+	return s
+}
+
+// Pipeline chains steps together, running each in turn against ctx and stopping at the
+// first error. Once the pipeline has stopped, however it stopped, every deferred cleanup
+// belonging to a step whose run succeeded is run, in reverse order, against deferCtx; the
+// failing step's own cleanup is not run.
+//
+// If a step fails, its error becomes the cause attached to deferCtx (context.Cause),
+// unless deferCtx was already cancelled with a cause of its own (e.g. a recovery
+// watchdog firing independently of any step). This lets deferred steps distinguish
+// "pause phase expired" from "user cancelled" instead of observing a generic
+// "context canceled".
+func Pipeline(steps ...*PipelineStep) func(ctx, deferCtx context.Context) error {
+	return func(ctx context.Context, deferCtx context.Context) error {
+		deferCtx, cancel := context.WithCancelCause(deferCtx)
+		defer cancel(nil)
+
+		ran := make([]*PipelineStep, 0, len(steps))
+		var stepErr error
+
+		for _, s := range steps {
+			if err := s.run(ctx); err != nil {
+				stepErr = err
+				break
+			}
+
+			ran = append(ran, s)
+		}
+
+		if stepErr != nil {
+			cancel(stepErr)
+		}
+
+		for i := len(ran) - 1; i >= 0; i-- {
+			if s := ran[i]; s.deferred != nil {
+				if err := s.deferred(deferCtx); err != nil && stepErr == nil {
+					stepErr = err
+				}
+			}
+		}
+
+		if stepErr != nil {
+			return errors.Wrapf(stepErr, "failover pipeline failed (cause: %s)", causeOrNone(deferCtx))
+		}
+
+		return nil
+	}
+}
+
+// causeOrNone returns the cause attached to ctx by Pipeline's cancellation, or "none" if
+// ctx has not been cancelled. Deferred steps use this to log why they're running instead
+// of a generic "context canceled".
+func causeOrNone(ctx context.Context) string {
+	if cause := context.Cause(ctx); cause != nil {
+		return cause.Error()
+	}
+
+	return "none"
+}